@@ -59,6 +59,18 @@ changes are detected.
 
 - optional (in process types): does not start this process unless explicit told
 so. The process type must be part of a group.
+
+- maxruntime (in process types): maximum duration the process type is allowed to
+run (e.g. "maxruntime=10m"). Once it expires, the process type is stopped and
+considered failed.
+
+- backoff (in process types): initial delay before restarting the process type
+after a failure (e.g. "backoff=1s"), overriding -restart-backoff. It doubles at
+every consecutive failure, up to one minute.
+
+- poststop (in process types): command executed every time the process type
+stops (e.g. "poststop=./cleanup.sh"). Commands with spaces must be quoted (e.g.
+poststop="rm -rf tmp").
 */
 package main // import "cirello.io/runner"
 
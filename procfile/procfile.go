@@ -60,6 +60,18 @@
 // - optional (in process types): does not start this process unless explicit
 // told so.
 //
+// - maxruntime (in process types): maximum duration the process type is allowed
+// to run (e.g. "maxruntime=10m"). Once it expires, the process type is stopped
+// and considered failed.
+//
+// - backoff (in process types): initial delay before restarting the process
+// type after a failure (e.g. "backoff=1s"), overriding the runner one. It
+// doubles at every consecutive failure, up to one minute.
+//
+// - poststop (in process types): command executed every time the process type
+// stops (e.g. "poststop=./cleanup.sh"). Commands with spaces must be quoted
+// (e.g. poststop="rm -rf tmp").
+//
 // Although internally runner.Runner supports waitbefore and multi-command
 // processes, for simplicity of interface these features have been disabled in
 // Procfile parser.
@@ -67,10 +79,12 @@ package procfile // import "cirello.io/runner/procfile"
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"cirello.io/runner/runner"
 )
@@ -116,7 +130,8 @@ func Parse(r io.Reader) (*runner.Runner, error) {
 			proc := runner.ProcessType{Name: procType}
 			parts := strings.Split(command, " ")
 			var command []string
-			for _, part := range parts {
+			for i := 0; i < len(parts); i++ {
+				part := parts[i]
 				if strings.HasPrefix(part, "waitfor=") {
 					proc.WaitFor = strings.TrimPrefix(part, "waitfor=")
 					continue
@@ -131,13 +146,43 @@ func Parse(r io.Reader) (*runner.Runner, error) {
 				}
 				if strings.HasPrefix(part, "restart=") {
 					restartMode := strings.TrimPrefix(part, "restart=")
-					proc.Restart = runner.ParseRestartMode(restartMode)
+					mode, ok := runner.LookupRestartMode(restartMode)
+					if !ok {
+						// kept as written for runner.Validate to report.
+						mode = runner.RestartMode(restartMode)
+					}
+					proc.Restart = mode
 					continue
 				}
 				if strings.HasPrefix(part, "group=") {
 					proc.Group = strings.TrimPrefix(part, "group=")
 					continue
 				}
+				if strings.HasPrefix(part, "maxruntime=") {
+					maxRuntime, err := time.ParseDuration(strings.TrimPrefix(part, "maxruntime="))
+					if err != nil {
+						return rnr, err
+					}
+					proc.MaxRuntime = maxRuntime
+					continue
+				}
+				if strings.HasPrefix(part, "backoff=") {
+					initial, err := time.ParseDuration(strings.TrimPrefix(part, "backoff="))
+					if err != nil {
+						return rnr, err
+					}
+					proc.RestartBackoff = &runner.Backoff{Initial: initial}
+					continue
+				}
+				if strings.HasPrefix(part, "poststop=") {
+					postStop, n, err := quotedOption(parts[i:], "poststop=")
+					if err != nil {
+						return rnr, err
+					}
+					proc.PostStop = postStop
+					i += n
+					continue
+				}
 				if strings.HasPrefix(part, "optional=") {
 					optional, err := strconv.ParseBool(strings.TrimPrefix(part, "optional="))
 					if err != nil {
@@ -155,3 +200,22 @@ func Parse(r io.Reader) (*runner.Runner, error) {
 
 	return rnr, scanner.Err()
 }
+
+// quotedOption reads the value of the option that starts parts. Values wrapped
+// in single or double quotes may span several parts, and it reports how many
+// parts after the first one the value used.
+func quotedOption(parts []string, prefix string) (value string, consumed int, err error) {
+	value = strings.TrimPrefix(parts[0], prefix)
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return value, 0, nil
+	}
+	rest := strings.Join(parts, " ")[len(prefix):]
+	end := strings.IndexByte(rest[1:], rest[0]) + 1
+	if end == 0 {
+		return "", 0, fmt.Errorf("unterminated quote in %s%s", prefix, rest)
+	}
+	if end+1 < len(rest) && rest[end+1] != ' ' {
+		return "", 0, fmt.Errorf("unexpected text after quoted %s%s", prefix, rest[:end+1])
+	}
+	return rest[1:end], strings.Count(rest[:end], " "), nil
+}
@@ -15,10 +15,12 @@
 package procfile
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"cirello.io/runner/runner"
 )
@@ -120,6 +122,45 @@ func TestParseErrors(t *testing.T) {
 		}
 	})
 
+	t.Run("restart=sometimes", func(t *testing.T) {
+		example := `web: restart=sometimes ./server serve`
+		got, err := Parse(strings.NewReader(example))
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if err := got.Validate(); !errors.Is(err, runner.ErrInvalidRestartMode) {
+			t.Error("unknown restart modes should be reported by Validate, got:", err)
+		}
+	})
+
+	t.Run("backoff=a", func(t *testing.T) {
+		example := `web: backoff=a ./server serve`
+		if _, err := Parse(strings.NewReader(example)); err == nil {
+			t.Error("invalid backoff should be an error")
+		}
+	})
+
+	t.Run("poststopUnterminated", func(t *testing.T) {
+		example := `web: poststop="rm -rf tmp ./server serve`
+		if _, err := Parse(strings.NewReader(example)); err == nil {
+			t.Error("unterminated poststop quotes should be an error")
+		}
+	})
+
+	t.Run("poststopTrailingText", func(t *testing.T) {
+		example := `web: poststop="rm -rf"tmp ./server serve`
+		if _, err := Parse(strings.NewReader(example)); err == nil {
+			t.Error("text right after the poststop quotes should be an error")
+		}
+	})
+
+	t.Run("maxruntime=a", func(t *testing.T) {
+		example := `web: maxruntime=a ./server serve`
+		if _, err := Parse(strings.NewReader(example)); err == nil {
+			t.Error("invalid maxruntime should be an error")
+		}
+	})
+
 	t.Run("empty", func(t *testing.T) {
 		example := `formation:     `
 		got, err := Parse(strings.NewReader(example))
@@ -131,3 +172,57 @@ func TestParseErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		example string
+		want    runner.ProcessType
+	}{
+		{"backoff", `web: backoff=2s ./server serve`, runner.ProcessType{
+			Name:           "web",
+			Cmd:            []string{"./server serve"},
+			RestartBackoff: &runner.Backoff{Initial: 2 * time.Second},
+		}},
+		{"maxruntime", `web: maxruntime=90s ./server serve`, runner.ProcessType{
+			Name:       "web",
+			Cmd:        []string{"./server serve"},
+			MaxRuntime: 90 * time.Second,
+		}},
+		{"maxruntimeWithRestart", `web: restart=fail maxruntime=1m ./server serve`, runner.ProcessType{
+			Name:       "web",
+			Cmd:        []string{"./server serve"},
+			Restart:    runner.OnFailure,
+			MaxRuntime: time.Minute,
+		}},
+		{"poststop", `web: poststop=./cleanup.sh ./server serve`, runner.ProcessType{
+			Name:     "web",
+			Cmd:      []string{"./server serve"},
+			PostStop: "./cleanup.sh",
+		}},
+		{"poststopQuoted", `web: poststop="rm -rf  tmp" ./server serve`, runner.ProcessType{
+			Name:     "web",
+			Cmd:      []string{"./server serve"},
+			PostStop: "rm -rf  tmp",
+		}},
+		{"poststopSingleQuoted", `web: poststop='echo "bye"' ./server serve`, runner.ProcessType{
+			Name:     "web",
+			Cmd:      []string{"./server serve"},
+			PostStop: `echo "bye"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.example))
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if len(got.Processes) != 1 {
+				t.Fatalf("expected one process type, got: %d", len(got.Processes))
+			}
+			if !reflect.DeepEqual(*got.Processes[0], tt.want) {
+				t.Errorf("parser did not get the right result. got: %#v\nexpected:%#v", *got.Processes[0], tt.want)
+			}
+		})
+	}
+}
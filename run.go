@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"cirello.io/runner/procfile"
 	"cirello.io/runner/runner"
@@ -46,6 +47,26 @@ func run() {
 			Name:  "convert",
 			Usage: "takes a declared Procfile and prints as JSON to standard output",
 		},
+		cli.BoolFlag{
+			Name:  "validate",
+			Usage: "checks a declared Procfile for configuration errors and exits",
+		},
+		cli.BoolFlag{
+			Name:  "fail-fast",
+			Usage: "halts the runner if the initial build fails",
+		},
+		cli.DurationFlag{
+			Name:  "stop-timeout",
+			Usage: "how long processes are given to exit after SIGTERM before being killed. If zero, processes are killed right away. Only the shell running each command is signalled.",
+		},
+		cli.DurationFlag{
+			Name:  "restart-backoff",
+			Usage: "initial delay before restarting a failed process, doubled at every consecutive failure. If zero, processes are restarted right away.",
+		},
+		cli.DurationFlag{
+			Name:  "restart-backoff-max",
+			Usage: "maximum delay before restarting a failed process (default 1m)",
+		},
 		cli.IntFlag{
 			Name:  "port",
 			Value: 5000,
@@ -93,6 +114,11 @@ func mainRunner(c *cli.Context) error {
 	origStdout := os.Stdout
 	basePort := c.Int("port")
 	convertToJSON := c.Bool("convert")
+	validate := c.Bool("validate")
+	failFast := c.Bool("fail-fast")
+	stopTimeout := c.Duration("stop-timeout")
+	restartBackoff := c.Duration("restart-backoff")
+	restartBackoffMax := c.Duration("restart-backoff-max")
 	envFN := c.String("env")
 	skipProcs := c.String("skip")
 	onlyProcs := c.String("only")
@@ -164,7 +190,8 @@ func mainRunner(c *cli.Context) error {
 
 	switch filepath.Ext(fn) {
 	case ".json":
-		if err := json.NewDecoder(fd).Decode(&s); err != nil {
+		s = runner.New()
+		if err := json.NewDecoder(fd).Decode(s); err != nil {
 			return fmt.Errorf("cannot parse spec file (json): %v", err)
 		}
 	default:
@@ -183,6 +210,14 @@ func mainRunner(c *cli.Context) error {
 		return nil
 	}
 
+	if validate {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("invalid spec file: %v", err)
+		}
+		fmt.Println("spec file is valid")
+		return nil
+	}
+
 	s.WorkDir = os.ExpandEnv(s.WorkDir)
 	if s.WorkDir == "" {
 		wd, err := os.Getwd()
@@ -193,7 +228,7 @@ func mainRunner(c *cli.Context) error {
 	}
 
 	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt)
+	signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go func() {
@@ -203,6 +238,18 @@ func mainRunner(c *cli.Context) error {
 	}()
 
 	s.BasePort = basePort
+	if failFast {
+		s.FailFast = true
+	}
+	if stopTimeout > 0 {
+		s.StopTimeout = stopTimeout
+	}
+	if restartBackoff > 0 {
+		s.RestartBackoff.Initial = restartBackoff
+	}
+	if restartBackoffMax > 0 {
+		s.RestartBackoff.Max = restartBackoffMax
+	}
 
 	if fd, err := os.Open(envFN); err == nil {
 		scanner := bufio.NewScanner(fd)
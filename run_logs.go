@@ -21,6 +21,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"cirello.io/runner/runner"
@@ -39,7 +40,7 @@ func logs() cli.Command {
 		},
 		Action: func(c *cli.Context) error {
 			interrupt := make(chan os.Signal, 1)
-			signal.Notify(interrupt, os.Interrupt)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
 			u := url.URL{Scheme: "ws", Host: c.GlobalString("service-discovery"), Path: "/logs"}
 			if filter := c.String("filter"); filter != "" {
@@ -0,0 +1,202 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRestartBackoff is the longest restart delay when Backoff.Max is
+// not set.
+const DefaultMaxRestartBackoff = time.Minute
+
+// Backoff defines how long the runner waits before restarting a process type
+// instance that failed. The restart after the first failure waits Initial, and
+// every consecutive failure waits Multiplier times longer than the previous,
+// up to Max (DefaultMaxRestartBackoff if zero). A zero Multiplier doubles the
+// wait, otherwise it must be at least 1. Jitter, between 0 and 1, randomizes
+// each wait by up to the given fraction of it, still within Max. An instance
+// that runs for at least Reset before stopping starts the backoff over. The
+// zero value disables the backoff.
+type Backoff struct {
+	Initial    time.Duration `json:"initial,omitempty"`
+	Max        time.Duration `json:"max,omitempty"`
+	Multiplier float64       `json:"multiplier,omitempty"`
+	Jitter     float64       `json:"jitter,omitempty"`
+
+	// Reset is how long an instance must run for its previous failures to
+	// be forgotten. If zero, it is the longest restart delay.
+	Reset time.Duration `json:"reset,omitempty"`
+}
+
+func (b Backoff) validate() error {
+	if b.Multiplier != 0 && b.Multiplier < 1 {
+		return fmt.Errorf("%w: multiplier %v is lower than 1", ErrInvalidBackoff, b.Multiplier)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return fmt.Errorf("%w: jitter %v is not between 0 and 1", ErrInvalidBackoff, b.Jitter)
+	}
+	return nil
+}
+
+func (b Backoff) delay(failures int) time.Duration {
+	if b.Initial <= 0 || failures <= 0 {
+		return 0
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	max := float64(b.max())
+	d := float64(b.Initial)
+	for i := 1; i < failures && d < max; i++ {
+		d *= multiplier
+	}
+	if jitter := math.Min(b.Jitter, 1); jitter > 0 {
+		d += d * jitter * (2*rand.Float64() - 1)
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(d)
+}
+
+// max is the longest restart delay.
+func (b Backoff) max() time.Duration {
+	if b.Max <= 0 {
+		return DefaultMaxRestartBackoff
+	}
+	return b.Max
+}
+
+// stable is how long an instance must run for its previous failures to be
+// forgotten.
+func (b Backoff) stable() time.Duration {
+	if b.Reset <= 0 {
+		return b.max()
+	}
+	return b.Reset
+}
+
+// instanceCounts tracks how many times a process type instance has started
+// and how many of its last runs failed in a row. Executions of an instance
+// that oversight has already given up on may still update it, so it has its
+// own lock.
+type instanceCounts struct {
+	mu       sync.Mutex
+	restarts int
+	failures int
+}
+
+// reset forgets the previous failures.
+func (c *instanceCounts) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+}
+
+// consecutiveFailures reports how many of the last runs failed in a row.
+func (c *instanceCounts) consecutiveFailures() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures
+}
+
+// start counts a new start and returns how many starts preceded it.
+func (c *instanceCounts) start() (restartCount int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	restartCount = c.restarts
+	c.restarts++
+	return restartCount
+}
+
+// finish records how a run that lasted uptime ended. Only failed runs count,
+// and a run that lasted at least stable forgets the failures before it.
+func (c *instanceCounts) finish(state ProcessState, uptime, stable time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state == Finished || uptime >= stable {
+		c.failures = 0
+	}
+	if state == Failed {
+		c.failures++
+	}
+}
+
+// trackInstance returns new counts for the named instance, replacing the ones
+// of its previous generation.
+func (r *Runner) trackInstance(procName string) *instanceCounts {
+	counts := &instanceCounts{}
+	r.instancesMu.Lock()
+	defer r.instancesMu.Unlock()
+	if r.instanceCounts == nil {
+		r.instanceCounts = make(map[string]*instanceCounts)
+	}
+	r.instanceCounts[procName] = counts
+	return counts
+}
+
+// ResetFailures forgets the consecutive failures of a process type instance
+// ("name.instance") or of all instances of a process type ("name"), so their
+// next restart is not delayed by the backoff. A restart that is already
+// waiting is not affected. It reports whether any instance was found.
+func (r *Runner) ResetFailures(name string) bool {
+	r.instancesMu.Lock()
+	defer r.instancesMu.Unlock()
+	found := false
+	for procName, counts := range r.instanceCounts {
+		if procName == name || strings.HasPrefix(procName, name+".") {
+			counts.reset()
+			found = true
+		}
+	}
+	return found
+}
+
+// restartBackoff is the backoff of sv, which defaults to the one of the
+// runner.
+func (r *Runner) restartBackoff(sv *ProcessType) Backoff {
+	if sv.RestartBackoff != nil {
+		return *sv.RestartBackoff
+	}
+	return r.RestartBackoff
+}
+
+// waitRestart blocks for the restart backoff b of the named instance after the
+// given number of consecutive failures, and reports when the restart is due
+// on Healthz meanwhile. It returns false if ctx is cancelled.
+func (r *Runner) waitRestart(ctx context.Context, procName string, b Backoff, failures int) bool {
+	d := b.delay(failures)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	r.setRestartAt(procName, time.Now().Add(d))
+	defer r.setRestartAt(procName, time.Time{})
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		failures int
+		want     time.Duration
+	}{
+		{"disabled", Backoff{}, 3, 0},
+		{"noFailures", Backoff{Initial: time.Second}, 0, 0},
+		{"firstFailure", Backoff{Initial: time.Second}, 1, time.Second},
+		{"defaultMultiplier", Backoff{Initial: time.Second}, 3, 4 * time.Second},
+		{"customMultiplier", Backoff{Initial: time.Second, Multiplier: 3}, 3, 9 * time.Second},
+		{"capped", Backoff{Initial: time.Second, Max: 5 * time.Second}, 10, 5 * time.Second},
+		{"cappedOverflow", Backoff{Initial: time.Second, Max: time.Minute}, 1000, time.Minute},
+		{"defaultCap", Backoff{Initial: time.Second}, 20, DefaultMaxRestartBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.delay(tt.failures); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("jitter", func(t *testing.T) {
+		b := Backoff{Initial: time.Second, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			if got := b.delay(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+				t.Fatalf("delay() = %v, want within 50%% of 1s", got)
+			}
+		}
+	})
+
+	t.Run("jitterClamped", func(t *testing.T) {
+		b := Backoff{Initial: time.Second, Jitter: 5}
+		for i := 0; i < 100; i++ {
+			if got := b.delay(1); got < 0 || got > 2*time.Second {
+				t.Fatalf("delay() = %v, want within 100%% of 1s", got)
+			}
+		}
+	})
+
+	t.Run("jitterCapped", func(t *testing.T) {
+		b := Backoff{Initial: time.Second, Max: 2 * time.Second, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			if got := b.delay(5); got < time.Second || got > 2*time.Second {
+				t.Fatalf("delay() = %v, want between 1s and the 2s cap", got)
+			}
+		}
+	})
+}
+
+func TestInstanceCounts(t *testing.T) {
+	const stable = time.Minute
+	tests := []struct {
+		name   string
+		state  ProcessState
+		uptime time.Duration
+		want   int
+	}{
+		{"failed", Failed, time.Second, 3},
+		{"stopped", Stopped, time.Second, 2},
+		{"finished", Finished, time.Second, 0},
+		{"failedAfterStableRun", Failed, stable, 1},
+		{"stoppedAfterStableRun", Stopped, stable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counts instanceCounts
+			for i := 0; i < 2; i++ {
+				if got := counts.start(); got != i {
+					t.Errorf("restart count = %d, want %d", got, i)
+				}
+				counts.finish(Failed, time.Second, stable)
+			}
+			counts.start()
+			if counts.finish(tt.state, tt.uptime, stable); counts.consecutiveFailures() != tt.want {
+				t.Errorf("consecutive failures = %d, want %d", counts.consecutiveFailures(), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		wantErr bool
+	}{
+		{"disabled", Backoff{}, false},
+		{"defaults", Backoff{Initial: time.Second}, false},
+		{"valid", Backoff{Initial: time.Second, Multiplier: 1.5, Jitter: 1}, false},
+		{"lowMultiplier", Backoff{Initial: time.Second, Multiplier: 0.5}, true},
+		{"negativeJitter", Backoff{Initial: time.Second, Jitter: -0.1}, true},
+		{"highJitter", Backoff{Initial: time.Second, Jitter: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.Processes = []*ProcessType{{Name: "web", Cmd: []string{"./server"}}}
+			r.RestartBackoff = tt.backoff
+			err := r.Validate()
+			if got := errors.Is(err, ErrInvalidBackoff); got != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			r.RestartBackoff = Backoff{}
+			backoff := tt.backoff
+			r.Processes[0].RestartBackoff = &backoff
+			err = r.Validate()
+			if got := errors.Is(err, ErrInvalidBackoff); got != tt.wantErr {
+				t.Errorf("Validate() with process type backoff = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartBackoffOverride(t *testing.T) {
+	r := New()
+	r.RestartBackoff = Backoff{Initial: time.Second}
+	web := &ProcessType{Name: "web"}
+	worker := &ProcessType{Name: "worker", RestartBackoff: &Backoff{Initial: time.Minute}}
+	if got := r.restartBackoff(web); got != r.RestartBackoff {
+		t.Errorf("restartBackoff(web) = %+v, want the runner backoff", got)
+	}
+	if got := r.restartBackoff(worker); got != *worker.RestartBackoff {
+		t.Errorf("restartBackoff(worker) = %+v, want its own backoff", got)
+	}
+}
+
+func TestBackoffStable(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		want    time.Duration
+	}{
+		{"default", Backoff{}, DefaultMaxRestartBackoff},
+		{"max", Backoff{Max: 10 * time.Second}, 10 * time.Second},
+		{"reset", Backoff{Max: 10 * time.Second, Reset: time.Hour}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.stable(); got != tt.want {
+				t.Errorf("stable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetFailures(t *testing.T) {
+	r := New()
+	counts := map[string]*instanceCounts{}
+	for _, procName := range []string{"web.0", "web.1", "webhook.0"} {
+		counts[procName] = r.trackInstance(procName)
+		counts[procName].finish(Failed, 0, time.Minute)
+	}
+	if r.ResetFailures("db") {
+		t.Error("ResetFailures() should report unknown process types")
+	}
+	if !r.ResetFailures("web.1") {
+		t.Error("ResetFailures() should find web.1")
+	}
+	if counts["web.0"].consecutiveFailures() != 1 || counts["web.1"].consecutiveFailures() != 0 {
+		t.Error("ResetFailures(web.1) should only reset web.1")
+	}
+	r.ResetFailures("web")
+	if counts["web.0"].consecutiveFailures() != 0 || counts["webhook.0"].consecutiveFailures() != 1 {
+		t.Error("ResetFailures(web) should reset all web instances and nothing else")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// jsonDuration decodes either a duration string, like "10m", or an integer
+// number of nanoseconds, which is how time.Duration is encoded.
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(v)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*d = jsonDuration(n)
+	return nil
+}
+
+// UnmarshalJSON accepts MaxRuntime as a duration string or in nanoseconds.
+func (p *ProcessType) UnmarshalJSON(b []byte) error {
+	type processType ProcessType
+	aux := struct {
+		*processType
+		MaxRuntime jsonDuration `json:"maxruntime,omitempty"`
+	}{processType: (*processType)(p), MaxRuntime: jsonDuration(p.MaxRuntime)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	p.MaxRuntime = time.Duration(aux.MaxRuntime)
+	return nil
+}
+
+// UnmarshalJSON accepts Initial, Max and Reset as duration strings or in
+// nanoseconds.
+func (b *Backoff) UnmarshalJSON(data []byte) error {
+	type backoff Backoff
+	aux := struct {
+		*backoff
+		Initial jsonDuration `json:"initial,omitempty"`
+		Max     jsonDuration `json:"max,omitempty"`
+		Reset   jsonDuration `json:"reset,omitempty"`
+	}{
+		backoff: (*backoff)(b),
+		Initial: jsonDuration(b.Initial),
+		Max:     jsonDuration(b.Max),
+		Reset:   jsonDuration(b.Reset),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	b.Initial, b.Max, b.Reset = time.Duration(aux.Initial), time.Duration(aux.Max), time.Duration(aux.Reset)
+	return nil
+}
+
+// UnmarshalJSON accepts StopTimeout as a duration string or in nanoseconds.
+// It also initializes what New would, so a zero Runner can be decoded into.
+func (r *Runner) UnmarshalJSON(b []byte) error {
+	type runner Runner
+	aux := struct {
+		*runner
+		StopTimeout jsonDuration
+	}{runner: (*runner)(r), StopTimeout: jsonDuration(r.StopTimeout)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	r.StopTimeout = time.Duration(aux.StopTimeout)
+	if r.Formation == nil {
+		r.Formation = make(map[string]int)
+	}
+	if r.dynamicServiceDiscovery == nil {
+		r.dynamicServiceDiscovery = make(map[string]string)
+	}
+	if r.logs == nil {
+		r.logs = make(chan LogMessage, websocketLogForwarderBufferSize)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalJSONDurations(t *testing.T) {
+	const spec = `{
+		"StopTimeout": "5s",
+		"RestartBackoff": {"initial": "1s", "max": 60000000000, "multiplier": 3, "jitter": 0.1, "reset": "1h"},
+		"procs": [
+			{"name": "a", "cmd": ["true"], "restart": "fail", "maxruntime": "10m"},
+			{"name": "b", "cmd": ["true"], "maxruntime": 1000000000}
+		]
+	}`
+	r := New()
+	if err := json.Unmarshal([]byte(spec), r); err != nil {
+		t.Fatal("cannot decode spec:", err)
+	}
+	if r.StopTimeout != 5*time.Second {
+		t.Errorf("StopTimeout = %v, want 5s", r.StopTimeout)
+	}
+	if want := (Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 3, Jitter: 0.1, Reset: time.Hour}); r.RestartBackoff != want {
+		t.Errorf("RestartBackoff = %+v, want %+v", r.RestartBackoff, want)
+	}
+	if len(r.Processes) != 2 {
+		t.Fatalf("got %d processes, want 2", len(r.Processes))
+	}
+	if got := r.Processes[0]; got.MaxRuntime != 10*time.Minute || got.Restart != OnFailure || got.Cmd[0] != "true" {
+		t.Errorf("unexpected process type: %+v", got)
+	}
+	if got := r.Processes[1].MaxRuntime; got != time.Second {
+		t.Errorf("maxruntime = %v, want 1s", got)
+	}
+	if r.Formation == nil || r.dynamicServiceDiscovery == nil {
+		t.Error("decoding should keep the fields initialized by New")
+	}
+
+	t.Run("roundTrip", func(t *testing.T) {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal("cannot encode runner:", err)
+		}
+		got := New()
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Fatal("cannot decode encoded runner:", err)
+		}
+		if got.StopTimeout != r.StopTimeout || got.RestartBackoff != r.RestartBackoff || got.Processes[0].MaxRuntime != r.Processes[0].MaxRuntime {
+			t.Errorf("round trip changed durations: %+v", got)
+		}
+	})
+
+	t.Run("zeroRunner", func(t *testing.T) {
+		var got Runner
+		if err := json.Unmarshal([]byte(spec), &got); err != nil {
+			t.Fatal("cannot decode spec:", err)
+		}
+		if got.Formation == nil || got.dynamicServiceDiscovery == nil || got.logs == nil {
+			t.Error("decoding into a zero Runner should initialize it like New")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, spec := range []string{
+			`{"procs": [{"name": "a", "maxruntime": "ten minutes"}]}`,
+			`{"RestartBackoff": {"initial": true}}`,
+			`{"StopTimeout": "5"}`,
+		} {
+			if err := json.Unmarshal([]byte(spec), New()); err == nil {
+				t.Errorf("%s: invalid duration should be an error", spec)
+			}
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ProcessState is the last known state of a process type instance.
+type ProcessState string
+
+// Process states
+const (
+	// Starting processes are executing the commands that precede the last
+	// one.
+	Starting ProcessState = "starting"
+
+	// Waiting processes are probing their WaitBefore or WaitFor targets.
+	Waiting ProcessState = "waiting"
+
+	// Running processes are executing their last command.
+	Running ProcessState = "running"
+
+	// Finished processes have executed all their commands successfully.
+	Finished ProcessState = "finished"
+
+	// Stopped processes were interrupted by the runner, either because of
+	// a rebuild, a group failure or a shutdown.
+	Stopped ProcessState = "stopped"
+
+	// Failed processes have had one of their commands failing.
+	Failed ProcessState = "failed"
+)
+
+// Health reports the state of every process type instance the runner has
+// started so far. Builds are reported by their process type name and
+// everything else by "name.instance". RestartAt lists when the instances
+// waiting for their restart backoff are going to be started again.
+type Health struct {
+	Healthy   bool                    `json:"healthy"`
+	Processes map[string]ProcessState `json:"processes"`
+	RestartAt map[string]time.Time    `json:"restartAt,omitempty"`
+}
+
+// Healthz reports the state of the processes. The runner is healthy when none
+// of them has failed.
+func (r *Runner) Healthz() Health {
+	r.sdMu.Lock()
+	defer r.sdMu.Unlock()
+	h := Health{
+		Healthy:   true,
+		Processes: make(map[string]ProcessState, len(r.processStates)),
+	}
+	for name, state := range r.processStates {
+		h.Processes[name] = state
+		if state == Failed {
+			h.Healthy = false
+		}
+	}
+	if len(r.restartAt) > 0 {
+		h.RestartAt = make(map[string]time.Time, len(r.restartAt))
+		for name, at := range r.restartAt {
+			h.RestartAt[name] = at
+		}
+	}
+	return h
+}
+
+func (r *Runner) setProcessState(procName string, state ProcessState) {
+	r.sdMu.Lock()
+	if r.processStates == nil {
+		r.processStates = make(map[string]ProcessState)
+	}
+	r.processStates[procName] = state
+	r.sdMu.Unlock()
+}
+
+// setRestartAt records when the named instance is going to be restarted. The
+// zero time clears it.
+func (r *Runner) setRestartAt(procName string, at time.Time) {
+	r.sdMu.Lock()
+	defer r.sdMu.Unlock()
+	if at.IsZero() {
+		delete(r.restartAt, procName)
+		return
+	}
+	if r.restartAt == nil {
+		r.restartAt = make(map[string]time.Time)
+	}
+	r.restartAt[procName] = at
+}
+
+func (r *Runner) serveHealthz(w http.ResponseWriter, _ *http.Request) {
+	health := r.Healthz()
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(health); err != nil {
+		log.Println("cannot serve health request:", err)
+	}
+}
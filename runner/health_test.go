@@ -0,0 +1,160 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	r := New()
+	if h := r.Healthz(); !h.Healthy || len(h.Processes) != 0 {
+		t.Errorf("empty runner should be healthy, got: %#v", h)
+	}
+	r.setProcessState("build-server", Finished)
+	r.setProcessState("web.0", Running)
+	r.setProcessState("web.1", Stopped)
+	if h := r.Healthz(); !h.Healthy || len(h.Processes) != 3 {
+		t.Errorf("runner without failed processes should be healthy, got: %#v", h)
+	}
+	r.setProcessState("web.1", Failed)
+	h := r.Healthz()
+	if h.Healthy {
+		t.Error("runner with failed processes should not be healthy")
+	}
+	if state := h.Processes["web.1"]; state != Failed {
+		t.Errorf("web.1 state = %q, want %q", state, Failed)
+	}
+}
+
+func TestServeHealthz(t *testing.T) {
+	r := New()
+	r.setProcessState("web.0", Running)
+	check := func(wantCode int, wantHealthy bool) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		r.serveHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rec.Code != wantCode {
+			t.Errorf("status code = %d, want %d", rec.Code, wantCode)
+		}
+		var h Health
+		if err := json.NewDecoder(rec.Body).Decode(&h); err != nil {
+			t.Fatal("cannot decode health response:", err)
+		}
+		if h.Healthy != wantHealthy || h.Processes["web.0"] == "" {
+			t.Errorf("unexpected health response: %#v", h)
+		}
+	}
+	check(http.StatusOK, true)
+	r.setProcessState("web.0", Failed)
+	check(http.StatusServiceUnavailable, false)
+}
+
+func TestStartProcessState(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         string
+		stopAfter   time.Duration
+		wantState   ProcessState
+		stopTimeout time.Duration
+		maxRuntime  time.Duration
+	}{
+		{"finished", "true", 0, Finished, 0, 0},
+		{"failed", "false", 0, Failed, 0, 0},
+		{"killed", "sleep 5", 200 * time.Millisecond, Stopped, 0, 0},
+		{"cleanExitOnSIGTERM", "trap 'exit 0' TERM; sleep 5 >/dev/null & wait", 200 * time.Millisecond, Stopped, time.Second, 0},
+		{"maxRuntime", "sleep 5", 0, Failed, 0, 200 * time.Millisecond},
+		{"cleanExitOnMaxRuntime", "trap 'exit 0' TERM; sleep 5 >/dev/null & wait", 0, Failed, time.Second, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = t.TempDir()
+			r.StopTimeout = tt.stopTimeout
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.stopAfter > 0 {
+				time.AfterFunc(tt.stopAfter, cancel)
+			}
+			sv := &ProcessType{Name: "web", Cmd: []string{tt.cmd}, MaxRuntime: tt.maxRuntime}
+			if got := r.startProcess(ctx, sv, 0, 0, 0, "", ioutil.Discard); got != tt.wantState {
+				t.Errorf("startProcess() = %q, want %q", got, tt.wantState)
+			}
+			if state := r.Healthz().Processes["web.0"]; state != tt.wantState {
+				t.Errorf("state = %q, want %q", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestStartProcessStateBeforePostStop(t *testing.T) {
+	r := New()
+	r.WorkDir = t.TempDir()
+	sv := &ProcessType{Name: "web", Cmd: []string{"true"}, PostStop: "sleep 0.5"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.startProcess(context.Background(), sv, 0, 0, 0, "", ioutil.Discard)
+	}()
+	time.Sleep(250 * time.Millisecond)
+	if state := r.Healthz().Processes["web.0"]; state != Finished {
+		t.Errorf("state during post-stop = %q, want %q", state, Finished)
+	}
+	<-done
+}
+
+func TestHealthzRestartAt(t *testing.T) {
+	r := New()
+	backoff := Backoff{Initial: 300 * time.Millisecond}
+	if !r.waitRestart(context.Background(), "web.0", backoff, 0) {
+		t.Fatal("waitRestart() without failures should not be cancelled")
+	}
+	if h := r.Healthz(); h.RestartAt != nil {
+		t.Errorf("restart without backoff should not be reported, got: %v", h.RestartAt)
+	}
+
+	before := time.Now()
+	done := make(chan bool)
+	go func() { done <- r.waitRestart(context.Background(), "web.0", backoff, 1) }()
+	time.Sleep(100 * time.Millisecond)
+	at, ok := r.Healthz().RestartAt["web.0"]
+	if !ok {
+		t.Fatal("restart backoff should be reported while waiting")
+	}
+	if at.Before(before.Add(300*time.Millisecond)) || at.After(time.Now().Add(300*time.Millisecond)) {
+		t.Errorf("restart at %v, want 300ms after %v", at, before)
+	}
+	if !<-done {
+		t.Error("waitRestart() should have waited the whole backoff")
+	}
+	if h := r.Healthz(); h.RestartAt != nil {
+		t.Errorf("restart should be cleared once due, got: %v", h.RestartAt)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if r.waitRestart(ctx, "web.0", backoff, 1) {
+		t.Error("waitRestart() should report cancellation")
+	}
+	if h := r.Healthz(); h.RestartAt != nil {
+		t.Errorf("restart should be cleared on cancellation, got: %v", h.RestartAt)
+	}
+}
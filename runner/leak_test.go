@@ -0,0 +1,89 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// checkLeaks fails t if fn leaves goroutines or file descriptors behind once
+// it returns. Goroutines get a grace period to notice that their work is
+// done.
+func checkLeaks(t *testing.T, fn func()) {
+	t.Helper()
+	goroutines, fds := runtime.NumGoroutine(), openFDs(t)
+	fn()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("leaked %d goroutines", n-goroutines)
+	}
+	if n := openFDs(t); n > fds {
+		t.Errorf("leaked %d file descriptors", n-fds)
+	}
+}
+
+func openFDs(t *testing.T) int {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open file descriptors:", err)
+	}
+	return len(fds)
+}
+
+func TestStartProcessLeaks(t *testing.T) {
+	tests := []struct {
+		name string
+		sv   ProcessType
+		stop time.Duration
+	}{
+		{"finished", ProcessType{Name: "web", Cmd: []string{"true", "echo done"}}, 0},
+		{"failed", ProcessType{Name: "web", Cmd: []string{"false"}}, 0},
+		{"stopped", ProcessType{Name: "web", Cmd: []string{"sleep 5"}}, 100 * time.Millisecond},
+		{"cancelled", ProcessType{Name: "web", Cmd: []string{"true", "true"}}, -1},
+		{"cancelledWhileWaiting", ProcessType{Name: "web", Cmd: []string{"true"}, WaitFor: "localhost:1"}, 100 * time.Millisecond},
+		{"cancelledWithPostStop", ProcessType{Name: "web", Cmd: []string{"true"}, PostStop: "true"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = t.TempDir()
+			r.ServiceDiscoveryAddr = ""
+			runs := 1
+			if tt.stop < 0 {
+				runs = 50
+			}
+			checkLeaks(t, func() {
+				for i := 0; i < runs; i++ {
+					ctx, cancel := context.WithCancel(context.Background())
+					if tt.stop < 0 {
+						cancel()
+					} else if tt.stop > 0 {
+						time.AfterFunc(tt.stop, cancel)
+					}
+					r.startProcess(ctx, &tt.sv, 0, 0, 0, "", ioutil.Discard)
+					cancel()
+				}
+			})
+		})
+	}
+}
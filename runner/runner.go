@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	oversight "cirello.io/oversight/easy"
@@ -40,24 +42,90 @@ import (
 // normalizes them.
 var ErrNonUniqueProcessTypeName = errors.New("non unique process type name")
 
+// ErrMissingCommand is returned when validating the runner, it detects a
+// process type without any command to execute.
+var ErrMissingCommand = errors.New("missing command")
+
+// ErrInvalidRestartMode is returned when validating the runner, it detects a
+// process type with an unknown restart mode.
+var ErrInvalidRestartMode = errors.New("invalid restart mode")
+
+// ErrInvalidFormation is returned when validating the runner, it detects a
+// formation with a negative number of instances.
+var ErrInvalidFormation = errors.New("invalid formation")
+
+// ErrInvalidBackoff is returned when validating the runner, it detects a
+// restart backoff with a multiplier lower than 1 or a jitter outside [0, 1].
+var ErrInvalidBackoff = errors.New("invalid restart backoff")
+
+// ErrInitialBuildFailed is returned when starting the runner with FailFast
+// set, and the first round of builds fails.
+var ErrInitialBuildFailed = errors.New("initial build failed")
+
+// ValidationError lists all the problems found in a runner configuration.
+type ValidationError []error
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the problems found matches target.
+func (v ValidationError) Is(target error) bool {
+	for _, err := range v {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // RestartMode defines if a process should restart itself.
 type RestartMode string
 
 // ParseRestartMode takes a string and converts to RestartMode. If the parsing
 // fails, it silently defaults to Never.
 func ParseRestartMode(m string) RestartMode {
+	mode, _ := LookupRestartMode(m)
+	return mode
+}
+
+// LookupRestartMode converts a string to RestartMode, reporting whether it is
+// one of the known aliases. Unknown strings convert to Never.
+func LookupRestartMode(m string) (RestartMode, bool) {
 	switch strings.ToLower(m) {
 	case "yes", "always", "true", "1":
-		return Always
+		return Always, true
 	case "fail", "failure", "onfail", "onfailure", "on-failure", "on_failure":
-		return OnFailure
+		return OnFailure, true
 	case "temporary", "start-once", "temp", "tmp":
-		return Temporary
+		return Temporary, true
 	case "loop":
-		return Loop
+		return Loop, true
+	case "", "no", "never", "false", "0":
+		return Never, true
 	default:
-		return Never
+		return Never, false
+	}
+}
+
+// UnmarshalJSON accepts any of the restart mode aliases understood by
+// ParseRestartMode. Unknown modes are kept as written, so Validate can report
+// them.
+func (m *RestartMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	mode, ok := LookupRestartMode(s)
+	if !ok {
+		mode = RestartMode(s)
 	}
+	*m = mode
+	return nil
 }
 
 // Restart modes
@@ -71,6 +139,9 @@ const (
 
 const websocketLogForwarderBufferSize = 102400
 
+// postStopTimeout is how long a PostStop command may run before it is killed.
+const postStopTimeout = time.Minute
+
 // ProcessType is the piece of software you want to start. Cmd accepts multiple
 // commands. All commands are executed in order of declaration. The last command
 // is considered the call which activates the process type. If WaitBefore is
@@ -120,6 +191,22 @@ type ProcessType struct {
 	// Optional processes are the ones skipped by default during start. The
 	// user must explicitly tell this process to start.
 	Optional bool
+
+	// PostStop is the command executed once the process type has stopped,
+	// regardless of whether it finished, failed or was interrupted by a
+	// restart. It runs once per stop, in its own shell, and is killed if it
+	// takes longer than a minute. It is not guaranteed to run when the runner
+	// itself shuts down.
+	PostStop string `json:"poststop,omitempty"`
+
+	// MaxRuntime is the maximum time the process type is allowed to run,
+	// including the time spent waiting for WaitBefore and WaitFor. Once it
+	// expires, the process type is stopped and considered failed.
+	MaxRuntime time.Duration `json:"maxruntime,omitempty"`
+
+	// RestartBackoff overrides the RestartBackoff of the runner for this
+	// process type.
+	RestartBackoff *Backoff `json:"restartbackoff,omitempty"`
 }
 
 // Runner defines how this application should be started.
@@ -154,6 +241,23 @@ type Runner struct {
 	// the service.
 	BaseEnvironment []string
 
+	// FailFast makes the runner halt if the first round of builds fails,
+	// instead of waiting for file changes to retry them.
+	FailFast bool
+
+	// StopTimeout is how long a process type is given to exit after being
+	// sent SIGTERM, once the runner decides to stop it, before it is
+	// killed. If zero, processes are killed right away. The signal is sent
+	// to the shell running the command only, so commands that spawn other
+	// processes, like pipelines, should exec the long-running one.
+	StopTimeout time.Duration
+
+	// RestartBackoff defines how long the runner waits before restarting a
+	// process type instance that has failed, unless the process type sets
+	// its own. A successful or long enough run resets it. Rebuilds are not
+	// delayed, and the backoff starts over at every rebuild.
+	RestartBackoff Backoff
+
 	longestProcessTypeName int
 
 	// ServiceDiscoveryAddr is the net.Listen address used to bind the
@@ -165,8 +269,14 @@ type Runner struct {
 	sdMu                    sync.Mutex
 	dynamicServiceDiscovery map[string]string
 	staticServiceDiscovery  []string
+	processStates           map[string]ProcessState
+	restartAt               map[string]time.Time
 	currentGeneration       int
 
+	instancesMu    sync.Mutex
+	instances      map[string]chan struct{}
+	instanceCounts map[string]*instanceCounts
+
 	logsMu         sync.RWMutex
 	logs           chan LogMessage
 	logSubscribers []chan LogMessage
@@ -190,21 +300,11 @@ func New() *Runner {
 
 // Start initiates the application.
 func (r *Runner) Start(rootCtx context.Context) error {
-	nameDict := make(map[string]struct{})
+	if len(r.nonUniqueProcessTypeNames()) > 0 {
+		return ErrNonUniqueProcessTypeName
+	}
 	for _, proc := range r.Processes {
-		name := proc.Name
-		if formation, ok := r.Formation[proc.Name]; ok {
-			name = fmt.Sprintf("%v.%v", proc.Name, formation)
-		} else {
-			name = fmt.Sprintf("%v.%v", proc.Name, 0)
-		}
-
-		if _, ok := nameDict[normalizeByEnvVarRules(name)]; ok {
-			return ErrNonUniqueProcessTypeName
-		}
-		nameDict[normalizeByEnvVarRules(name)] = struct{}{}
-
-		if l := len(name); l > r.longestProcessTypeName {
+		if l := len(processTypeInstanceName(proc.Name, r.Formation)); l > r.longestProcessTypeName {
 			r.longestProcessTypeName = l
 		}
 	}
@@ -231,6 +331,7 @@ func (r *Runner) Start(rootCtx context.Context) error {
 
 	run := make(chan string)
 	fileHashes := make(map[string]string) // fn to hash
+	firstBuild := true
 	c, cancel := context.WithCancel(rootCtx)
 	for {
 		select {
@@ -247,9 +348,19 @@ func (r *Runner) Start(rootCtx context.Context) error {
 			fileHashes[fn] = newHash
 
 			if ok := r.runBuilds(c, fn); !ok {
+				// builds interrupted by a shutdown are not failures.
+				if rootCtx.Err() != nil {
+					cancel()
+					return nil
+				}
+				if r.FailFast && firstBuild {
+					cancel()
+					return ErrInitialBuildFailed
+				}
 				log.Println("error during build, halted")
 				continue
 			}
+			firstBuild = false
 
 			if l := len(updates); l == 0 {
 				cancel()
@@ -264,6 +375,70 @@ func (r *Runner) Start(rootCtx context.Context) error {
 	}
 }
 
+// Validate checks the runner configuration without starting anything. It
+// reports all problems found at once as a ValidationError. Start only refuses
+// to run configurations with non unique process type names, for which it
+// returns ErrNonUniqueProcessTypeName itself.
+func (r *Runner) Validate() error {
+	var errs ValidationError
+	for _, name := range r.nonUniqueProcessTypeNames() {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrNonUniqueProcessTypeName, name))
+	}
+	for _, proc := range r.Processes {
+		hasCmd := false
+		for _, cmd := range proc.Cmd {
+			if strings.TrimSpace(cmd) != "" {
+				hasCmd = true
+				break
+			}
+		}
+		if !hasCmd {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrMissingCommand, proc.Name))
+		}
+
+		switch proc.Restart {
+		case Always, OnFailure, Temporary, Loop, Never:
+		default:
+			errs = append(errs, fmt.Errorf("%w: %s (%q)", ErrInvalidRestartMode, proc.Name, proc.Restart))
+		}
+
+		if proc.RestartBackoff != nil {
+			if err := proc.RestartBackoff.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", proc.Name, err))
+			}
+		}
+	}
+	for name, count := range r.Formation {
+		if count < 0 {
+			errs = append(errs, fmt.Errorf("%w: %s=%d", ErrInvalidFormation, name, count))
+		}
+	}
+	if err := r.RestartBackoff.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (r *Runner) nonUniqueProcessTypeNames() []string {
+	var names []string
+	nameDict := make(map[string]struct{})
+	for _, proc := range r.Processes {
+		name := normalizeByEnvVarRules(processTypeInstanceName(proc.Name, r.Formation))
+		if _, ok := nameDict[name]; ok {
+			names = append(names, proc.Name)
+		}
+		nameDict[name] = struct{}{}
+	}
+	return names
+}
+
+func processTypeInstanceName(name string, formation map[string]int) string {
+	return fmt.Sprintf("%v.%v", name, formation[name])
+}
+
 func calcFileHash(fn string) string {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -310,7 +485,7 @@ func (r *Runner) runBuilds(ctx context.Context, fn string) bool {
 				log.Println(sv.Name, "is sticky")
 				c = context.Background()
 			}
-			if !r.startProcess(c, sv, -1, -1, fn, &buf) {
+			if r.startProcess(c, sv, -1, -1, 0, fn, &buf) != Finished {
 				mu.Lock()
 				ok = false
 				localOk = false
@@ -350,12 +525,20 @@ func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName stri
 		portCount := j * 100
 		for i := 0; i < maxProc; i++ {
 			sv, i, pc := sv, i, portCount
+			procName := fmt.Sprintf("%v.%v", sv.Name, i)
+			counts := r.trackInstance(procName)
 
 			if sv.Restart == Loop && r.currentGeneration == 0 {
 				loopSvcCtx := oversight.WithContext(rootCtx)
 				oversight.Add(loopSvcCtx, func(ctx context.Context) error {
 					<-ready
-					r.startProcess(ctx, sv, i, pc, changedFileName, ioutil.Discard)
+					backoff := r.restartBackoff(sv)
+					if !r.waitRestart(ctx, procName, backoff, counts.consecutiveFailures()) {
+						return nil
+					}
+					started := time.Now()
+					state := r.startProcess(ctx, sv, i, pc, counts.start(), changedFileName, ioutil.Discard)
+					counts.finish(state, time.Since(started), backoff.stable())
 					return nil
 				}, oversight.RestartWith(oversight.Permanent()))
 				portCount++
@@ -363,7 +546,7 @@ func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName stri
 				temporarySvcCtx := oversight.WithContext(rootCtx)
 				oversight.Add(temporarySvcCtx, func(ctx context.Context) error {
 					<-ready
-					r.startProcess(ctx, sv, i, pc, changedFileName, ioutil.Discard)
+					r.startProcess(ctx, sv, i, pc, counts.start(), changedFileName, ioutil.Discard)
 					return nil
 				}, oversight.RestartWith(oversight.Temporary()))
 				portCount++
@@ -380,8 +563,19 @@ func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName stri
 				}
 				oversight.Add(procCtx, func(ctx context.Context) error {
 					<-ready
-					ok := r.startProcess(ctx, sv, i, pc, changedFileName, ioutil.Discard)
-					if !ok && sv.Restart == OnFailure {
+					backoff := r.restartBackoff(sv)
+					if !r.waitRestart(ctx, procName, backoff, counts.consecutiveFailures()) {
+						return nil
+					}
+					unlock, locked := r.lockInstance(ctx, sv.Name, i)
+					if !locked {
+						return nil
+					}
+					defer unlock()
+					started := time.Now()
+					state := r.startProcess(ctx, sv, i, pc, counts.start(), changedFileName, ioutil.Discard)
+					counts.finish(state, time.Since(started), backoff.stable())
+					if state != Finished && sv.Restart == OnFailure {
 						return errors.New("restarting on failure")
 					}
 					return nil
@@ -400,6 +594,34 @@ func (r *Runner) runNonBuilds(rootCtx, ctx context.Context, changedFileName stri
 	<-ctx.Done()
 }
 
+// lockInstance serializes the executions of a process type instance, so that
+// the instance started by a rebuild or a restart only runs after the previous
+// one has fully stopped. It gives up, returning false, if ctx is cancelled
+// before the previous execution stops.
+func (r *Runner) lockInstance(ctx context.Context, name string, procCount int) (unlock func(), ok bool) {
+	key := fmt.Sprintf("%v.%v", name, procCount)
+	r.instancesMu.Lock()
+	if r.instances == nil {
+		r.instances = make(map[string]chan struct{})
+	}
+	l, ok := r.instances[key]
+	if !ok {
+		l = make(chan struct{}, 1)
+		r.instances[key] = l
+	}
+	r.instancesMu.Unlock()
+	select {
+	case l <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false
+	}
+	if ctx.Err() != nil {
+		<-l
+		return nil, false
+	}
+	return func() { <-l }, true
+}
+
 func discoveryEnvVar(name string, procCount int) string {
 	return normalizeByEnvVarRules(fmt.Sprintf("%s_%d_PORT", name, procCount))
 }
@@ -422,7 +644,9 @@ func normalizeByEnvVarRules(name string) string {
 	return strings.ToUpper(buf.String())
 }
 
-func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount int, changedFileName string, buf io.Writer) bool {
+// startProcess runs the commands of sv and reports how they ended: Finished,
+// Failed or Stopped.
+func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, portCount, restartCount int, changedFileName string, buf io.Writer) (state ProcessState) {
 	pr, pw := io.Pipe()
 	procName := sv.Name
 	port := r.BasePort + portCount
@@ -437,6 +661,21 @@ func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, p
 	defer pw.Close()
 	defer pr.Close()
 
+	parentCtx := ctx
+	if sv.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sv.MaxRuntime)
+		defer cancel()
+	}
+
+	if sv.PostStop != "" {
+		defer r.runPostStop(pw, sv, procName, r.processEnv(procName, port, portCount, restartCount, changedFileName))
+	}
+
+	state = Failed
+	defer func() { r.setProcessState(procName, state) }()
+	r.setProcessState(procName, Starting)
+
 	for idx, cmd := range sv.Cmd {
 		fmt.Fprintln(pw, "running", `"`+cmd+`"`)
 		defer fmt.Fprintln(pw, "finished", `"`+cmd+`"`)
@@ -444,53 +683,119 @@ func (r *Runner) startProcess(ctx context.Context, sv *ProcessType, procCount, p
 			fmt.Fprintln(pw, "listening on", port)
 		}
 		fmt.Fprintln(pw)
-		c := exec.CommandContext(ctx, "sh", "-c", cmd)
+		c := exec.Command("sh", "-c", cmd)
 		c.Dir = r.WorkDir
-
-		c.Env = os.Environ()
-		if len(r.BaseEnvironment) > 0 {
-			c.Env = r.BaseEnvironment
-		}
-		c.Env = append(c.Env, fmt.Sprintf("PS=%v", procName))
-		if portCount > -1 {
-			c.Env = append(c.Env, fmt.Sprintf("PORT=%d", port))
-		}
-
-		if r.ServiceDiscoveryAddr != "" {
-			c.Env = append(c.Env, fmt.Sprintf("DISCOVERY=%v", r.ServiceDiscoveryAddr))
-			c.Env = append(c.Env, r.staticServiceDiscovery...)
-		}
-
-		c.Env = append(c.Env, fmt.Sprintf("CHANGED_FILENAME=%v", changedFileName))
-
-		stderrPipe, err := c.StderrPipe()
-		if err != nil {
-			fmt.Fprintln(pw, "cannot open stderr pipe", procName, cmd)
-			continue
-		}
-		stdoutPipe, err := c.StdoutPipe()
-		if err != nil {
-			fmt.Fprintln(pw, "cannot open stdout pipe", procName, cmd)
-			continue
-		}
-
-		r.prefixedPrinter(ctx, io.TeeReader(stderrPipe, buf), procName)
-		r.prefixedPrinter(ctx, io.TeeReader(stdoutPipe, buf), procName)
+		c.Env = r.processEnv(procName, port, portCount, restartCount, changedFileName)
 
 		isFirstCommand := idx == 0
 		isLastCommand := idx+1 == len(sv.Cmd)
 		if isFirstCommand && sv.WaitBefore != "" {
+			r.setProcessState(procName, Waiting)
 			r.waitFor(ctx, pw, sv.WaitBefore)
 		} else if isLastCommand && sv.WaitFor != "" {
+			r.setProcessState(procName, Waiting)
 			r.waitFor(ctx, pw, sv.WaitFor)
 		}
+		if isLastCommand {
+			r.setProcessState(procName, Running)
+		} else {
+			r.setProcessState(procName, Starting)
+		}
 
-		if err := c.Run(); err != nil {
+		err := r.runCommand(ctx, c, procName, buf)
+		if err != nil {
 			fmt.Fprintf(pw, "exec error %s: (%s) %v\n", procName, cmd, err)
-			return false
 		}
+		// A command that handles the stop signal may exit cleanly, so
+		// whether it was interrupted is told by the context, not by err.
+		if parentCtx.Err() != nil {
+			return Stopped
+		} else if ctx.Err() != nil {
+			fmt.Fprintln(pw, "exceeded max runtime of", sv.MaxRuntime)
+			return Failed
+		} else if err != nil {
+			return Failed
+		}
+	}
+	return Finished
+}
+
+// runCommand executes c, printing its output under procName and copying it to
+// buf, and stops it once ctx is cancelled. If StopTimeout is set, c is asked
+// to terminate first and only killed if it does not exit in time. Only c
+// itself is signalled, its children are left untouched. The output pipes are
+// only opened once c is about to start: exec.Cmd closes them in Start or Wait,
+// so a command that is never started would leak them.
+func (r *Runner) runCommand(ctx context.Context, c *exec.Cmd, procName string, buf io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	stderrPipe, err := c.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("cannot open stderr pipe: %w", err)
+	}
+	stdoutPipe, err := c.StdoutPipe()
+	if err != nil {
+		stderrPipe.Close()
+		c.Stderr.(io.Closer).Close()
+		return fmt.Errorf("cannot open stdout pipe: %w", err)
+	}
+	r.prefixedPrinter(ctx, io.TeeReader(stderrPipe, buf), procName)
+	r.prefixedPrinter(ctx, io.TeeReader(stdoutPipe, buf), procName)
+	if err := c.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		if r.StopTimeout > 0 {
+			if err := c.Process.Signal(syscall.SIGTERM); err == nil {
+				select {
+				case <-done:
+					return
+				case <-time.After(r.StopTimeout):
+				}
+			}
+		}
+		c.Process.Kill()
+	}()
+	return c.Wait()
+}
+
+func (r *Runner) processEnv(procName string, port, portCount, restartCount int, changedFileName string) []string {
+	env := os.Environ()
+	if len(r.BaseEnvironment) > 0 {
+		env = r.BaseEnvironment
+	}
+	env = append(env, fmt.Sprintf("PS=%v", procName))
+	env = append(env, fmt.Sprintf("RESTART_COUNT=%d", restartCount))
+	if portCount > -1 {
+		env = append(env, fmt.Sprintf("PORT=%d", port))
+	}
+
+	if r.ServiceDiscoveryAddr != "" {
+		env = append(env, fmt.Sprintf("DISCOVERY=%v", r.ServiceDiscoveryAddr))
+		env = append(env, r.staticServiceDiscovery...)
+	}
+
+	return append(env, fmt.Sprintf("CHANGED_FILENAME=%v", changedFileName))
+}
+
+func (r *Runner) runPostStop(w io.Writer, sv *ProcessType, procName string, env []string) {
+	fmt.Fprintln(w, "running post-stop", `"`+sv.PostStop+`"`)
+	ctx, cancel := context.WithTimeout(context.Background(), postStopTimeout)
+	defer cancel()
+	c := exec.Command("sh", "-c", sv.PostStop)
+	c.Dir = r.WorkDir
+	c.Env = env
+	if err := r.runCommand(ctx, c, procName, ioutil.Discard); err != nil {
+		fmt.Fprintf(w, "post-stop error %s: (%s) %v\n", procName, sv.PostStop, err)
 	}
-	return true
 }
 
 func (r *Runner) waitFor(ctx context.Context, w io.Writer, target string) {
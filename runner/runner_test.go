@@ -0,0 +1,304 @@
+// Copyright 2017 github.com/ucirello
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		processes []*ProcessType
+		formation map[string]int
+		wantErrs  []error
+	}{
+		{"valid", []*ProcessType{{Name: "web", Cmd: []string{"./server"}}}, nil, nil},
+		{"duplicated", []*ProcessType{{Name: "web", Cmd: []string{"a"}}, {Name: "WEB", Cmd: []string{"b"}}}, nil, []error{ErrNonUniqueProcessTypeName}},
+		{"missingCommand", []*ProcessType{{Name: "web", Cmd: []string{" "}}}, nil, []error{ErrMissingCommand}},
+		{"invalidRestart", []*ProcessType{{Name: "web", Cmd: []string{"a"}, Restart: "sometimes"}}, nil, []error{ErrInvalidRestartMode}},
+		{"invalidFormation", []*ProcessType{{Name: "web", Cmd: []string{"a"}}}, map[string]int{"web": -1}, []error{ErrInvalidFormation}},
+		{"many", []*ProcessType{{Name: "web"}, {Name: "web", Cmd: []string{"a"}}}, nil, []error{ErrMissingCommand, ErrNonUniqueProcessTypeName}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.Processes = tt.processes
+			if tt.formation != nil {
+				r.Formation = tt.formation
+			}
+			err := r.Validate()
+			if len(tt.wantErrs) == 0 && err != nil {
+				t.Fatal("unexpected error:", err)
+			}
+			for _, want := range tt.wantErrs {
+				if !errors.Is(err, want) {
+					t.Errorf("Validate() = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	const spec = `{"procs": [
+		{"name": "a", "cmd": ["true"], "restart": "no"},
+		{"name": "b", "cmd": ["true"], "restart": "always"},
+		{"name": "c", "cmd": ["true"], "restart": "on-failure"},
+		{"name": "d", "cmd": ["true"], "restart": "tmp"},
+		{"name": "e", "cmd": ["true"], "restart": "loop"}
+	]}`
+	r := New()
+	if err := json.Unmarshal([]byte(spec), r); err != nil {
+		t.Fatal("cannot decode spec:", err)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal("restart mode aliases should be valid:", err)
+	}
+	want := []RestartMode{Never, Always, OnFailure, Temporary, Loop}
+	for i, proc := range r.Processes {
+		if proc.Restart != want[i] {
+			t.Errorf("%s: restart = %q, want %q", proc.Name, proc.Restart, want[i])
+		}
+	}
+}
+
+func TestValidateJSONUnknownRestartMode(t *testing.T) {
+	const spec = `{"procs": [{"name": "a", "cmd": ["true"], "restart": "sometimes"}]}`
+	r := New()
+	if err := json.Unmarshal([]byte(spec), r); err != nil {
+		t.Fatal("cannot decode spec:", err)
+	}
+	if got := r.Processes[0].Restart; got != "sometimes" {
+		t.Errorf("unknown restart modes should be kept as written, got %q", got)
+	}
+	if err := r.Validate(); !errors.Is(err, ErrInvalidRestartMode) {
+		t.Errorf("Validate() = %v, want %v", err, ErrInvalidRestartMode)
+	}
+}
+
+func TestStartNonUniqueNames(t *testing.T) {
+	r := New()
+	r.Processes = []*ProcessType{{Name: "web", Cmd: []string{"a"}}, {Name: "web"}}
+	if err := r.Start(context.Background()); err != ErrNonUniqueProcessTypeName {
+		t.Errorf("Start() = %v, want %v", err, ErrNonUniqueProcessTypeName)
+	}
+}
+
+func TestLockInstance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow restart test in short mode")
+	}
+	workDir := t.TempDir()
+	r := New()
+	r.WorkDir = workDir
+	r.ServiceDiscoveryAddr = ""
+	r.StopTimeout = 10 * time.Second
+	r.Processes = []*ProcessType{
+		{Name: "crash", Group: "g", Restart: OnFailure, Cmd: []string{"sleep 0.1; exit 1"}},
+		// The first slow instance ignores SIGTERM and outlives the time
+		// oversight waits for it to stop, so without the instance lock the
+		// replacement started by the crash would run alongside it.
+		{Name: "slow", Group: "g", Restart: Always, Cmd: []string{
+			`trap "" TERM; mkdir running || touch overlap; echo >> starts; if mkdir first; then sleep 5.5; else sleep 0.2; fi; rmdir running`,
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.runNonBuilds(ctx, ctx, "")
+	starts := func() int {
+		b, _ := ioutil.ReadFile(filepath.Join(workDir, "starts"))
+		return strings.Count(string(b), "\n")
+	}
+	// oversight waits 5s for the first slow instance before restarting it.
+	for deadline := time.Now().Add(8 * time.Second); starts() < 2 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	// let the last slow instance finish before the workdir is removed.
+	running := filepath.Join(workDir, "running")
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if _, err := os.Stat(running); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(filepath.Join(workDir, "overlap")); err == nil {
+		t.Error("found overlapping executions of the same instance")
+	}
+	if n := starts(); n < 2 {
+		t.Errorf("slow should have been restarted by the crashes, started %d times", n)
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		unlock, ok := r.lockInstance(context.Background(), "web", 0)
+		if !ok {
+			t.Fatal("lockInstance() should lock a free instance")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, ok := r.lockInstance(ctx, "web", 0); ok {
+			t.Error("lockInstance() should give up once ctx is cancelled")
+		}
+		if _, ok := r.lockInstance(context.Background(), "web", 1); !ok {
+			t.Error("lockInstance() should not block other instances")
+		}
+		unlock()
+		if _, ok := r.lockInstance(ctx, "web", 0); ok {
+			t.Error("lockInstance() should not lock with a cancelled ctx")
+		}
+	})
+}
+
+func TestPostStop(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmd       string
+		stopAfter time.Duration
+	}{
+		{"finished", "true", 0},
+		{"failed", "false", 0},
+		{"stopped", "sleep 5", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = t.TempDir()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.stopAfter > 0 {
+				time.AfterFunc(tt.stopAfter, cancel)
+			}
+			sv := &ProcessType{Name: "web", Cmd: []string{tt.cmd}, PostStop: `echo $PS >> poststop.log`}
+			r.startProcess(ctx, sv, 0, 0, 0, "", ioutil.Discard)
+			got, err := ioutil.ReadFile(filepath.Join(r.WorkDir, "poststop.log"))
+			if err != nil {
+				t.Fatal("post-stop did not run:", err)
+			}
+			if want := "web.0\n"; string(got) != want {
+				t.Errorf("post-stop output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         string
+		stopTimeout time.Duration
+		wantErr     bool
+		minElapsed  time.Duration
+		maxElapsed  time.Duration
+	}{
+		{"handlesSIGTERM", "trap 'exit 0' TERM; sleep 5 >/dev/null & wait", 2 * time.Second, false, 0, time.Second},
+		{"ignoresSIGTERM", `trap "" TERM; sleep 5 >/dev/null & wait`, 500 * time.Millisecond, true, 500 * time.Millisecond, 2 * time.Second},
+		{"noStopTimeout", "trap 'exit 0' TERM; sleep 5 >/dev/null & wait", 0, true, 0, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.StopTimeout = tt.stopTimeout
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := exec.Command("sh", "-c", tt.cmd)
+			time.AfterFunc(200*time.Millisecond, cancel)
+			start := time.Now()
+			err := r.runCommand(ctx, c, "test", ioutil.Discard)
+			elapsed := time.Since(start) - 200*time.Millisecond
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if elapsed < tt.minElapsed || elapsed > tt.maxElapsed {
+				t.Errorf("runCommand() stopped after %v, want between %v and %v", elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := exec.Command("sh", "-c", "true")
+		checkLeaks(t, func() {
+			if err := New().runCommand(ctx, c, "test", ioutil.Discard); err != context.Canceled {
+				t.Errorf("runCommand() error = %v, want %v", err, context.Canceled)
+			}
+		})
+		if c.Process != nil || c.Stdout != nil || c.Stderr != nil {
+			t.Error("runCommand() should neither start nor attach pipes to commands with a cancelled context")
+		}
+	})
+
+	t.Run("startFailure", func(t *testing.T) {
+		c := exec.Command("sh", "-c", "true")
+		c.Dir = filepath.Join(t.TempDir(), "missing")
+		checkLeaks(t, func() {
+			if err := New().runCommand(context.Background(), c, "test", ioutil.Discard); err == nil {
+				t.Error("runCommand() should fail to start in a missing directory")
+			}
+		})
+		if c.Stdout == nil || c.Stderr == nil {
+			t.Error("runCommand() should have attached the output pipes")
+		}
+	})
+}
+
+func TestStartFailFast(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    string
+		failFast bool
+		stop     time.Duration
+		want     error
+	}{
+		{"failingBuild", "false", true, 0, ErrInitialBuildFailed},
+		{"failingBuildWithoutFailFast", "false", false, time.Second, nil},
+		{"interruptedBuild", "sleep 5", true, 500 * time.Millisecond, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			r.WorkDir = t.TempDir()
+			r.ServiceDiscoveryAddr = ""
+			r.FailFast = tt.failFast
+			r.Processes = []*ProcessType{{Name: "build", Cmd: []string{tt.build}}}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.stop > 0 {
+				time.AfterFunc(tt.stop, cancel)
+			}
+			errc := make(chan error, 1)
+			go func() { errc <- r.Start(ctx) }()
+			select {
+			case err := <-errc:
+				if err != tt.want {
+					t.Errorf("Start() = %v, want %v", err, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Start() did not return")
+			}
+		})
+	}
+}
@@ -88,6 +88,7 @@ func (r *Runner) serveWeb(ctx context.Context) error {
 				log.Println("cannot serve service discovery request:", err)
 			}
 		})
+		mux.HandleFunc("/healthz", r.serveHealthz)
 		mux.HandleFunc("/logs", func(w http.ResponseWriter, req *http.Request) {
 			filter := req.URL.Query().Get("filter")
 			mode := req.URL.Query().Get("mode")
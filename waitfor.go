@@ -24,6 +24,7 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	cli "github.com/urfave/cli"
@@ -43,7 +44,7 @@ func waitfor() {
 	}
 	app.Action = func(c *cli.Context) error {
 		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
+		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 		go func() {